@@ -0,0 +1,264 @@
+package telnet
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// clientBufferSize bounds how much unmatched output Expect/ExpectRegex
+// can Peek across before giving up with bufio.ErrBufferFull. The
+// default 4KB bufio.Reader size is too small for the banners, paged
+// "--More--" output and show-running-config-style dumps the Steps
+// engine is meant to handle.
+const clientBufferSize = 256 * 1024
+
+// Options configures Dial. It mirrors the connection-related Schema
+// fields without the scripted-dialogue ones (Command, ExpectedOutput,
+// Steps), since a Client is driven interactively instead.
+type Options struct {
+	Scheme               string
+	Username             string
+	Password             string
+	PrivateKey           string
+	PrivateKeyPassphrase string
+	TerminalType         string
+	Rows                 int
+	Cols                 int
+	NegotiateOptions     map[byte]bool
+	Trace                bool
+}
+
+func (o Options) schema(server string, port int) Schema {
+	return Schema{
+		Scheme:               o.Scheme,
+		Server:               server,
+		Port:                 port,
+		Username:             o.Username,
+		Password:             o.Password,
+		PrivateKey:           o.PrivateKey,
+		PrivateKeyPassphrase: o.PrivateKeyPassphrase,
+		TerminalType:         o.TerminalType,
+		Rows:                 o.Rows,
+		Cols:                 o.Cols,
+		NegotiateOptions:     o.NegotiateOptions,
+		Trace:                o.Trace,
+	}
+}
+
+// Client is a reusable telnet or SSH session. Unlike the one-shot Run,
+// callers can Dial once and then Expect/Send repeatedly, pipelining
+// commands or embedding the client in a larger tool.
+type Client struct {
+	w               io.Writer
+	buf             *bufio.Reader
+	pw              *io.PipeWriter
+	closer          io.Closer
+	setReadDeadline func(time.Time) error
+}
+
+// Dial connects to addr ("host:port") using opts and starts the IAC
+// handler (for the telnet scheme) in a background goroutine that
+// feeds a buffered reader, so Expect can Peek ahead without consuming
+// bytes a later call will need.
+func Dial(ctx context.Context, addr string, opts Options) (*Client, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in address %q: %w", addr, err)
+	}
+
+	conf := opts.schema(host, port)
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(30 * time.Second)
+	}
+
+	var tr *transport
+	var closer io.Closer
+	switch conf.Scheme {
+	case "ssh":
+		tr, closer, err = dialSSH(ctx, conf, deadline)
+	default:
+		tr, closer, err = dialTelnet(ctx, conf, deadline)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tr = withTrace(tr, conf, conf.Trace)
+
+	pr, pw := io.Pipe()
+	c := &Client{
+		w:               tr.w,
+		buf:             bufio.NewReaderSize(pr, clientBufferSize),
+		pw:              pw,
+		closer:          closer,
+		setReadDeadline: tr.setReadDeadline,
+	}
+	go c.pump(tr)
+
+	return c, nil
+}
+
+// pump reads raw bytes off tr, letting the telnet IAC handler (if any)
+// consume negotiation sequences, and writes everything else into the
+// pipe backing c.buf.
+func (c *Client) pump(tr *transport) {
+	one := make([]byte, 1)
+	for {
+		n, err := tr.r.Read(one)
+		if n > 0 {
+			if tr.iac != nil {
+				handled, iacErr := tr.iac(one[0])
+				if iacErr != nil {
+					c.pw.CloseWithError(iacErr)
+					return
+				}
+				if handled {
+					continue
+				}
+			}
+			if _, werr := c.pw.Write(one[:n]); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			c.pw.CloseWithError(err)
+			return
+		}
+	}
+}
+
+// Expect blocks until the buffered stream contains one of targets,
+// returning the matched text, the index of the target that matched,
+// and consuming exactly through the end of the match.
+func (c *Client) Expect(targets ...string) (string, int, error) {
+	return c.expect(targets, nil)
+}
+
+// ExpectRegex is Expect for compiled regular expressions.
+func (c *Client) ExpectRegex(res ...*regexp.Regexp) (string, int, error) {
+	return c.expect(nil, res)
+}
+
+func (c *Client) expect(targets []string, regexes []*regexp.Regexp) (string, int, error) {
+	// scanned is how much of the peeked buffer firstMatch has already
+	// ruled out; overlap bounds how far a new literal match could
+	// reach back behind it (a match can't start more than
+	// overlap-1 bytes before its end). Without this, growing n one
+	// byte at a time while rescanning from byte 0 every iteration is
+	// O(total unmatched bytes squared) - exactly the shape of input
+	// (large banners, paged output) clientBufferSize exists to hold.
+	// Converting the whole peeked buffer to a string every iteration
+	// would reintroduce the same quadratic cost via the copy, so the
+	// literal scan works directly on the []byte from Peek and only
+	// pays for a string conversion once, at the match (or error)
+	// boundary. Regex targets have no fixed-length bound to scan
+	// within, so they're always rescanned in full; in practice they're
+	// used sparingly (typically one final-step match), so that stays
+	// cheap.
+	overlap := longestTarget(targets)
+	scanned := 0
+
+	for n := 1; ; n++ {
+		peek, peekErr := c.buf.Peek(n)
+
+		from := scanned - overlap
+		if from < 0 {
+			from = 0
+		}
+
+		if idx, end := firstMatch(peek[from:], targets); end >= 0 {
+			end += from
+			if _, err := c.buf.Discard(end); err != nil {
+				return string(peek[:end]), idx, err
+			}
+			return string(peek[:end]), idx, nil
+		}
+
+		if len(regexes) > 0 {
+			s := string(peek)
+			if idx, end := firstRegexMatch(s, regexes); end >= 0 {
+				if _, err := c.buf.Discard(end); err != nil {
+					return s[:end], len(targets) + idx, err
+				}
+				return s[:end], len(targets) + idx, nil
+			}
+		}
+
+		scanned = len(peek)
+
+		if peekErr != nil {
+			return string(peek), -1, fmt.Errorf("expect: %w", peekErr)
+		}
+	}
+}
+
+// longestTarget returns the length of the longest literal target, used
+// by expect to bound how far behind its scan cursor a match could
+// still start.
+func longestTarget(targets []string) int {
+	max := 0
+	for _, t := range targets {
+		if len(t) > max {
+			max = len(t)
+		}
+	}
+	return max
+}
+
+func firstMatch(b []byte, targets []string) (idx, end int) {
+	for i, t := range targets {
+		if at := bytes.Index(b, []byte(t)); at >= 0 {
+			return i, at + len(t)
+		}
+	}
+	return -1, -1
+}
+
+func firstRegexMatch(s string, regexes []*regexp.Regexp) (idx, end int) {
+	for i, re := range regexes {
+		if loc := re.FindStringIndex(s); loc != nil {
+			return i, loc[1]
+		}
+	}
+	return -1, -1
+}
+
+// Send writes s verbatim.
+func (c *Client) Send(s string) error {
+	_, err := c.w.Write([]byte(s))
+	return err
+}
+
+// SendLine writes s followed by a telnet newline.
+func (c *Client) SendLine(s string) error {
+	return sendLine(c.w, s)
+}
+
+// SetReadDeadline bounds the next read performed by the background
+// pump goroutine. Returns an error if the underlying transport doesn't
+// support deadlines.
+func (c *Client) SetReadDeadline(t time.Time) error {
+	if c.setReadDeadline == nil {
+		return fmt.Errorf("transport does not support read deadlines")
+	}
+	return c.setReadDeadline(t)
+}
+
+// Close tears down the session.
+func (c *Client) Close() error {
+	c.pw.CloseWithError(io.EOF)
+	return c.closer.Close()
+}
@@ -0,0 +1,144 @@
+package telnet
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// newTestClient builds a Client around an in-memory reader/writer,
+// bypassing Dial so Expect/Send can be exercised without a real
+// network or SSH handshake.
+func newTestClient(r io.Reader, w io.Writer) *Client {
+	tr := &transport{r: r, w: w}
+	pr, pw := io.Pipe()
+	c := &Client{w: tr.w, buf: bufio.NewReaderSize(pr, clientBufferSize), pw: pw, closer: nopCloser{}}
+	go c.pump(tr)
+	return c
+}
+
+func TestClientExpectLeavesTrailingBytesForNextCall(t *testing.T) {
+	r, w := io.Pipe()
+	go func() {
+		w.Write([]byte("hello world\r\nextra"))
+		w.Close()
+	}()
+
+	c := newTestClient(r, io.Discard)
+	defer c.Close()
+
+	matched, idx, err := c.Expect("world")
+	if err != nil {
+		t.Fatalf("Expect() error = %v", err)
+	}
+	if idx != 0 || matched != "hello world" {
+		t.Fatalf("Expect() = (%q, %d), want (%q, 0)", matched, idx, "hello world")
+	}
+
+	rest, _, err := c.Expect("extra")
+	if err != nil {
+		t.Fatalf("second Expect() error = %v", err)
+	}
+	if rest != "\r\nextra" {
+		t.Fatalf("second Expect() = %q, want %q", rest, "\r\nextra")
+	}
+}
+
+func TestClientExpectSurvivesLongUnmatchedPrefix(t *testing.T) {
+	filler := strings.Repeat("x", 5000)
+
+	r, w := io.Pipe()
+	go func() {
+		w.Write([]byte(filler + "PROMPT>"))
+		w.Close()
+	}()
+
+	c := newTestClient(r, io.Discard)
+	defer c.Close()
+
+	matched, _, err := c.Expect("PROMPT>")
+	if err != nil {
+		t.Fatalf("Expect() error = %v, want nil (buffer should hold more than the 4KB bufio default)", err)
+	}
+	if matched != filler+"PROMPT>" {
+		t.Fatalf("Expect() returned %d bytes, want %d", len(matched), len(filler)+len("PROMPT>"))
+	}
+}
+
+func TestClientExpectScalesLinearlyWithUnmatchedPrefix(t *testing.T) {
+	// Regression guard for the O(n^2) rescan-from-byte-0 bug: with
+	// clientBufferSize raised to 256KB, a quadratic scan turns a
+	// realistic banner/show-running-config dump into multiple seconds
+	// of CPU per Expect call. A linear scan finishes this in
+	// milliseconds; give it a generous but still regression-catching
+	// budget.
+	filler := strings.Repeat("x", 200000)
+
+	r, w := io.Pipe()
+	go func() {
+		w.Write([]byte(filler + "PROMPT>"))
+		w.Close()
+	}()
+
+	c := newTestClient(r, io.Discard)
+	defer c.Close()
+
+	start := time.Now()
+	matched, _, err := c.Expect("PROMPT>")
+	elapsed := time.Since(start)
+
+	if err != nil {
+		t.Fatalf("Expect() error = %v", err)
+	}
+	if matched != filler+"PROMPT>" {
+		t.Fatalf("Expect() returned %d bytes, want %d", len(matched), len(filler)+len("PROMPT>"))
+	}
+	if elapsed > 2*time.Second {
+		t.Fatalf("Expect() took %v for a %d-byte unmatched prefix, want well under 2s (quadratic regression?)", elapsed, len(filler))
+	}
+}
+
+func TestClientExpectMatchesShortTargetAmongLongerOnes(t *testing.T) {
+	// Correctness check for the scan-cursor optimization: expect's scan
+	// window is sized off the longest target (here "PASSWORD:"), so a
+	// shorter target further along the stream ("# ") must still be
+	// found rather than scanned past.
+	filler := strings.Repeat("x", 100)
+
+	r, w := io.Pipe()
+	go func() {
+		w.Write([]byte(filler + "# "))
+		w.Close()
+	}()
+
+	c := newTestClient(r, io.Discard)
+	defer c.Close()
+
+	matched, idx, err := c.Expect("PASSWORD:", "# ")
+	if err != nil {
+		t.Fatalf("Expect() error = %v", err)
+	}
+	if idx != 1 || matched != filler+"# " {
+		t.Fatalf("Expect() = (%q, %d), want (%q, 1)", matched, idx, filler+"# ")
+	}
+}
+
+func TestClientSendLine(t *testing.T) {
+	var sent bytes.Buffer
+	c := newTestClient(strings.NewReader(""), &sent)
+	defer c.Close()
+
+	if err := c.SendLine("ls -la"); err != nil {
+		t.Fatalf("SendLine() error = %v", err)
+	}
+	if sent.String() != "ls -la\r\n" {
+		t.Fatalf("SendLine() wrote %q, want %q", sent.String(), "ls -la\r\n")
+	}
+}
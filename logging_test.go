@@ -0,0 +1,92 @@
+package telnet
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLoggerRedactsPassword(t *testing.T) {
+	const password = "hunter2"
+
+	var events []LogEvent
+	SetLogger(func(e LogEvent) {
+		events = append(events, e)
+	})
+	defer SetLogger(nil)
+
+	tr := &transport{r: strings.NewReader("login: alice\r\npassword echoed back: " + password + "\r\n")}
+	traced := withTrace(tr, Schema{Password: password}, true)
+
+	buf := make([]byte, 8)
+	for {
+		_, err := traced.r.Read(buf)
+		if err != nil {
+			break
+		}
+	}
+
+	if len(events) == 0 {
+		t.Fatal("expected at least one log event")
+	}
+	for _, e := range events {
+		if strings.Contains(e.Payload, password) {
+			t.Fatalf("logger payload contains verbatim password: %q", e.Payload)
+		}
+	}
+}
+
+// TestLoggerRedactsPasswordThroughPump exercises the real code path:
+// Client.pump reads one byte at a time off the traced transport, so
+// redaction must buffer across those single-byte reads rather than
+// matching the secret against each isolated byte.
+func TestLoggerRedactsPasswordThroughPump(t *testing.T) {
+	const password = "hunter2"
+
+	var events []LogEvent
+	SetLogger(func(e LogEvent) {
+		events = append(events, e)
+	})
+	defer SetLogger(nil)
+
+	r, w := io.Pipe()
+	go func() {
+		w.Write([]byte("password echoed back: " + password + "\r\n"))
+		w.Close()
+	}()
+
+	tr := withTrace(&transport{r: r, w: io.Discard}, Schema{Password: password}, true)
+
+	pr, pw := io.Pipe()
+	c := &Client{w: tr.w, buf: bufio.NewReader(pr), pw: pw, closer: nopCloser{}}
+	go c.pump(tr)
+	defer c.Close()
+
+	if _, err := io.Copy(io.Discard, c.buf); err != nil && err != io.ErrClosedPipe {
+		t.Fatalf("draining client buffer: %v", err)
+	}
+
+	if len(events) == 0 {
+		t.Fatal("expected at least one log event")
+	}
+
+	var concatenated strings.Builder
+	for _, e := range events {
+		concatenated.WriteString(e.Payload)
+	}
+	if strings.Contains(concatenated.String(), password) {
+		t.Fatalf("concatenated logger payloads contain verbatim password: %q", concatenated.String())
+	}
+}
+
+func TestWithTraceDisabledIsNoop(t *testing.T) {
+	tr := &transport{r: strings.NewReader("hello")}
+
+	SetLogger(func(LogEvent) {})
+	defer SetLogger(nil)
+
+	if got := withTrace(tr, Schema{}, false); got != tr {
+		t.Fatal("withTrace(enabled=false) should return the transport unchanged")
+	}
+}
@@ -1,11 +1,12 @@
 package telnet
 
 import (
-	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net"
-	"strings"
+	"time"
 
 	"github.com/scorify/schema"
 )
@@ -21,18 +22,114 @@ const (
 )
 
 type Schema struct {
+	// Scheme selects the transport: "telnet" (default) or "ssh". SSH
+	// is dialed via golang.org/x/crypto/ssh and bypasses the telnet
+	// IAC negotiation entirely.
+	Scheme         string `key:"scheme" default:"telnet"`
 	Server         string `key:"server"`
 	Port           int    `key:"port" default:"22"`
 	Username       string `key:"username"`
 	Password       string `key:"password"`
 	Command        string `key:"command"`
 	ExpectedOutput string `key:"expected_output"`
+
+	// PrivateKey and PrivateKeyPassphrase authenticate the SSH scheme
+	// with a key instead of (or in addition to) Password.
+	PrivateKey           string `key:"private_key"`
+	PrivateKeyPassphrase string `key:"private_key_passphrase"`
+
+	// TerminalType is reported in response to IAC SB TTYPE SEND.
+	TerminalType string `key:"terminal_type" default:"xterm"`
+	// Rows and Cols are reported via IAC SB NAWS once negotiated.
+	Rows int `key:"rows" default:"24"`
+	Cols int `key:"cols" default:"80"`
+	// NegotiateOptions overrides the default set of options we agree
+	// to negotiate (SGA, ECHO, TTYPE, NAWS), keyed by option code. It
+	// is not part of the JSON config schema (github.com/scorify/schema
+	// only supports string/int/bool fields) and can only be set
+	// programmatically, through Options.
+	NegotiateOptions map[byte]bool
+
+	// Steps scripts the expect/send dialogue. When empty, a default
+	// five-step login -> password -> shell prompt -> command -> output
+	// script is generated from Username, Password, Command and
+	// ExpectedOutput.
+	// Like NegotiateOptions, it's a slice and github.com/scorify/schema
+	// can't unmarshal it directly; parseConfig decodes it out of band
+	// with encoding/json under the "steps" key.
+	Steps []Step
+
+	// Trace enables structured send/receive logging via the Logger
+	// installed with SetLogger. Password and PrivateKeyPassphrase are
+	// redacted before anything is logged.
+	Trace bool `key:"trace"`
 }
 
-func Validate(config string) error {
-	conf := Schema{}
+// schemaFields mirrors the subset of Schema that is string/int/bool,
+// which is all github.com/scorify/schema's Unmarshal can populate: it
+// walks every field of the struct passed to it unconditionally and
+// errors on anything else (including a map or a slice of structs), so
+// NegotiateOptions and Steps must stay off of it.
+type schemaFields struct {
+	Scheme         string `key:"scheme" default:"telnet"`
+	Server         string `key:"server"`
+	Port           int    `key:"port" default:"22"`
+	Username       string `key:"username"`
+	Password       string `key:"password"`
+	Command        string `key:"command"`
+	ExpectedOutput string `key:"expected_output"`
+
+	PrivateKey           string `key:"private_key"`
+	PrivateKeyPassphrase string `key:"private_key_passphrase"`
 
-	err := schema.Unmarshal([]byte(config), &conf)
+	TerminalType string `key:"terminal_type" default:"xterm"`
+	Rows         int    `key:"rows" default:"24"`
+	Cols         int    `key:"cols" default:"80"`
+
+	Trace bool `key:"trace"`
+}
+
+// stepsWire decodes just the "steps" key of a config out of band from
+// schemaFields, using encoding/json directly instead of
+// github.com/scorify/schema (which has no slice-of-struct support).
+type stepsWire struct {
+	Steps []Step `json:"steps"`
+}
+
+// parseConfig builds a Schema from a raw JSON config string, combining
+// schema.Unmarshal (for the flat fields) with a plain encoding/json
+// decode (for Steps, which schema.Unmarshal can't represent).
+func parseConfig(config string) (Schema, error) {
+	flat := schemaFields{}
+	if err := schema.Unmarshal([]byte(config), &flat); err != nil {
+		return Schema{}, err
+	}
+
+	steps := stepsWire{}
+	if err := json.Unmarshal([]byte(config), &steps); err != nil {
+		return Schema{}, fmt.Errorf("failed decoding steps: %w", err)
+	}
+
+	return Schema{
+		Scheme:               flat.Scheme,
+		Server:               flat.Server,
+		Port:                 flat.Port,
+		Username:             flat.Username,
+		Password:             flat.Password,
+		Command:              flat.Command,
+		ExpectedOutput:       flat.ExpectedOutput,
+		PrivateKey:           flat.PrivateKey,
+		PrivateKeyPassphrase: flat.PrivateKeyPassphrase,
+		TerminalType:         flat.TerminalType,
+		Rows:                 flat.Rows,
+		Cols:                 flat.Cols,
+		Steps:                steps.Steps,
+		Trace:                flat.Trace,
+	}, nil
+}
+
+func Validate(config string) error {
+	conf, err := parseConfig(config)
 	if err != nil {
 		return err
 	}
@@ -45,176 +142,102 @@ func Validate(config string) error {
 		return fmt.Errorf("port must be between 1 and 65535; got %d", conf.Port)
 	}
 
-	if conf.Username == "" {
-		return fmt.Errorf("username is required; got %q", conf.Username)
-	}
-
-	if conf.Password == "" {
-		return fmt.Errorf("password is required; got %q", conf.Password)
-	}
-
-	if conf.Command == "" {
-		return fmt.Errorf("command is required; got %q", conf.Command)
-	}
-
-	return nil
-}
-
-// handleIAC reads the rest of an IAC sequence and sends the
-// appropriate refusal response. Returns true if an IAC was handled.
-func handleIAC(conn net.Conn, firstByte byte) bool {
-	if firstByte != IAC {
-		return false
-	}
-
-	cmd := make([]byte, 1)
-	if _, err := conn.Read(cmd); err != nil {
-		return true
-	}
-
-	switch cmd[0] {
-	case DO:
-		// Server asks us to DO something — refuse with WONT
-		opt := make([]byte, 1)
-		conn.Read(opt)
-		conn.Write([]byte{IAC, WONT, opt[0]})
-
-	case WILL:
-		// Server says it WILL do something — refuse with DONT
-		opt := make([]byte, 1)
-		conn.Read(opt)
-		conn.Write([]byte{IAC, DONT, opt[0]})
-
-	case WONT, DONT:
-		// Server refusing something, just consume the option byte
-		opt := make([]byte, 1)
-		conn.Read(opt)
-
-	case SB:
-		// Subnegotiation — read until IAC SE
-		one := make([]byte, 1)
-		for {
-			if _, err := conn.Read(one); err != nil {
-				return true
-			}
-			if one[0] == IAC {
-				if _, err := conn.Read(one); err != nil {
-					return true
-				}
-				if one[0] == SE {
-					break
-				}
-			}
+	// Username, Password/PrivateKey and Command are only required for
+	// the legacy default script (see defaultSteps); a caller providing
+	// Steps writes its own dialogue and may not need any of them.
+	if len(conf.Steps) == 0 {
+		if conf.Username == "" {
+			return fmt.Errorf("username is required; got %q", conf.Username)
 		}
 
-	default:
-		// Some other 2-byte IAC command, just consume it
-	}
-
-	return true
-}
-
-func readUntilAny(
-	conn net.Conn,
-	targets []string,
-) (string, error) {
-
-	var buf bytes.Buffer
-	one := make([]byte, 1)
-
-	for {
-		n, err := conn.Read(one)
-		if n > 0 {
-			if handleIAC(conn, one[0]) {
-				continue
-			}
-
-			buf.Write(one[:n])
-			s := buf.String()
-
-			for _, t := range targets {
-				if strings.Contains(s, t) {
-					return s, nil
-				}
+		if conf.Scheme == "ssh" {
+			if conf.Password == "" && conf.PrivateKey == "" {
+				return fmt.Errorf("password or private_key is required for the ssh scheme")
 			}
+		} else if conf.Password == "" {
+			return fmt.Errorf("password is required; got %q", conf.Password)
 		}
 
-		if err != nil {
-			return buf.String(), fmt.Errorf("read error waiting for %v: %w", targets, err)
+		if conf.Command == "" {
+			return fmt.Errorf("command is required; got %q", conf.Command)
 		}
 	}
+
+	switch conf.Scheme {
+	case "", "telnet", "ssh":
+	default:
+		return fmt.Errorf("scheme must be \"telnet\" or \"ssh\"; got %q", conf.Scheme)
+	}
+
+	return nil
 }
 
 // sendLine writes a string followed by a telnet newline (\r\n).
-func sendLine(conn net.Conn, line string) error {
-	_, err := conn.Write([]byte(line + "\r\n"))
+func sendLine(w io.Writer, line string) error {
+	_, err := w.Write([]byte(line + "\r\n"))
 	return err
 }
 
-func Run(ctx context.Context, config string) error {
-	conf := Schema{}
-
-	err := schema.Unmarshal([]byte(config), &conf)
-	if err != nil {
-		return err
-	}
-
-	deadline, ok := ctx.Deadline()
-	if !ok {
-		return fmt.Errorf("context deadline is not set")
-	}
-
+// dialTelnet connects to conf.Server:Port, negotiates telnet options
+// and returns a transport with the IAC handler wired in.
+func dialTelnet(ctx context.Context, conf Schema, deadline time.Time) (*transport, io.Closer, error) {
 	connStr := fmt.Sprintf("%s:%d", conf.Server, conf.Port)
 
 	dialer := net.Dialer{Deadline: deadline}
 	conn, err := dialer.DialContext(ctx, "tcp", connStr)
 	if err != nil {
-		return fmt.Errorf("failed to dial %s: %w", connStr, err)
+		return nil, nil, fmt.Errorf("failed to dial %s: %w", connStr, err)
 	}
-	defer conn.Close()
-
 	conn.SetDeadline(deadline)
 
-	// 1. Wait for login prompt
-	_, err = readUntilAny(conn, []string{"ogin:"})
-	if err != nil {
-		return fmt.Errorf("failed waiting for login prompt: %v", err)
+	sess := newSession(conn, conf)
+	if err := sess.startNegotiation(); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed starting option negotiation: %v", err)
 	}
 
-	if err := sendLine(conn, conf.Username); err != nil {
-		return fmt.Errorf("failed sending username: %v", err)
-	}
+	return sess.transport(), conn, nil
+}
 
-	// 2. Wait for password prompt
-	_, err = readUntilAny(conn, []string{"assword:"})
+// Run is a thin wrapper around Client for the scorify check runner: it
+// dials conf, runs the scripted dialogue (Steps, or a default script
+// generated from the legacy fields), and closes the session.
+func Run(ctx context.Context, config string) error {
+	conf, err := parseConfig(config)
 	if err != nil {
-		return fmt.Errorf("failed waiting for password prompt: %v", err)
+		return err
 	}
 
-	if err := sendLine(conn, conf.Username); err != nil {
-		return fmt.Errorf("failed sending password: %v", err)
+	if _, ok := ctx.Deadline(); !ok {
+		return fmt.Errorf("context deadline is not set")
 	}
 
-	// 3. Wait for shell prompt (more robust detection)
-	_, err = readUntilAny(conn, []string{"$ ", "# "})
+	addr := fmt.Sprintf("%s:%d", conf.Server, conf.Port)
+	client, err := Dial(ctx, addr, Options{
+		Scheme:               conf.Scheme,
+		Username:             conf.Username,
+		Password:             conf.Password,
+		PrivateKey:           conf.PrivateKey,
+		PrivateKeyPassphrase: conf.PrivateKeyPassphrase,
+		TerminalType:         conf.TerminalType,
+		Rows:                 conf.Rows,
+		Cols:                 conf.Cols,
+		NegotiateOptions:     conf.NegotiateOptions,
+		Trace:                conf.Trace,
+	})
 	if err != nil {
-		return fmt.Errorf("failed waiting for shell prompt: %v", err)
+		return err
 	}
+	defer client.Close()
 
-	// 4. Send command
-	if err := sendLine(conn, conf.Command); err != nil {
-		return fmt.Errorf("failed sending command: %v", err)
+	steps := conf.Steps
+	if len(steps) == 0 {
+		steps = defaultSteps(conf)
 	}
 
-	// 5. Read until prompt returns again
-	cmdOutput, err := readUntilAny(conn, []string{"$ ", "# "})
-	if err != nil {
-		return fmt.Errorf("failed reading command output: %v", err)
+	if _, err := runSteps(ctx, client, steps); err != nil {
+		return fmt.Errorf("failed running scripted dialogue: %w", err)
 	}
 
-	expected := []byte(conf.ExpectedOutput)
-	if !bytes.Contains([]byte(cmdOutput), expected) {
-		return fmt.Errorf("failed: outputs do not match; got: %s", cmdOutput)
-	}
 	return nil
 }
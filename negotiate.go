@@ -0,0 +1,411 @@
+package telnet
+
+import (
+	"net"
+)
+
+// Telnet option codes we actively negotiate. See RFC 854 and friends.
+const (
+	optBinary   = 0
+	optEcho     = 1
+	optSGA      = 3
+	optTType    = 24
+	optNAWS     = 31
+	optLinemode = 34
+)
+
+// Telnet subnegotiation commands.
+const (
+	ttypeIS   = 0
+	ttypeSEND = 1
+)
+
+// qState is one side's state in the RFC 1143 "Q Method" option
+// negotiation state machine. NO and YES are the steady states;
+// WANTNO/WANTYES are in-flight states, each carrying whether the
+// opposite request has been queued behind it.
+type qState int
+
+const (
+	qNo qState = iota
+	qYes
+	qWantNoEmpty
+	qWantNoOpposite
+	qWantYesEmpty
+	qWantYesOpposite
+)
+
+// optState tracks the independent local ("us") and remote ("them")
+// negotiation state for a single telnet option, per RFC 1143.
+type optState struct {
+	us   qState
+	them qState
+}
+
+// session wraps a telnet connection with RFC 1143 option negotiation,
+// replacing the old handleIAC blanket-refusal logic with real state
+// tracking per option and per side.
+type session struct {
+	conn net.Conn
+
+	options map[byte]*optState
+
+	// negotiate reports whether we should agree to enable a given
+	// option when the peer proposes it (or when we volunteer it).
+	// Defaults come from Schema.NegotiateOptions when set.
+	negotiate map[byte]bool
+
+	termType string
+	rows     uint16
+	cols     uint16
+}
+
+func newSession(conn net.Conn, conf Schema) *session {
+	negotiate := map[byte]bool{
+		optSGA:   true,
+		optEcho:  true,
+		optTType: true,
+		optNAWS:  true,
+	}
+	for opt, ok := range conf.NegotiateOptions {
+		negotiate[opt] = ok
+	}
+
+	termType := conf.TerminalType
+	if termType == "" {
+		termType = "xterm"
+	}
+
+	rows := conf.Rows
+	if rows <= 0 {
+		rows = 24
+	}
+	cols := conf.Cols
+	if cols <= 0 {
+		cols = 80
+	}
+
+	return &session{
+		conn:      conn,
+		options:   map[byte]*optState{},
+		negotiate: negotiate,
+		termType:  termType,
+		rows:      uint16(rows),
+		cols:      uint16(cols),
+	}
+}
+
+// transport exposes this telnet session as a generic transport, with
+// its IAC handler wired in so option negotiation stays transparent to
+// the expect/send engine.
+func (s *session) transport() *transport {
+	return &transport{
+		r:               s.conn,
+		w:               s.conn,
+		iac:             s.handleIAC,
+		setReadDeadline: s.conn.SetReadDeadline,
+	}
+}
+
+func (s *session) stateFor(opt byte) *optState {
+	st, ok := s.options[opt]
+	if !ok {
+		st = &optState{us: qNo, them: qNo}
+		s.options[opt] = st
+	}
+	return st
+}
+
+func (s *session) sendCmd(cmd, opt byte) error {
+	_, err := s.conn.Write([]byte{IAC, cmd, opt})
+	return err
+}
+
+// startNegotiation volunteers our preferred options at the start of a
+// session: WILL SGA, WILL ECHO (we drive the server's shell, so we
+// offer to echo), DO SGA, DO TTYPE and DO NAWS.
+func (s *session) startNegotiation() error {
+	type offer struct {
+		cmd byte
+		opt byte
+	}
+	offers := []offer{
+		{WILL, optSGA},
+		{WILL, optEcho},
+		{DO, optSGA},
+		{DO, optTType},
+		{DO, optNAWS},
+	}
+
+	for _, o := range offers {
+		if !s.negotiate[o.opt] {
+			continue
+		}
+		st := s.stateFor(o.opt)
+		switch o.cmd {
+		case WILL:
+			if st.us != qNo {
+				continue
+			}
+			st.us = qWantYesEmpty
+		case DO:
+			if st.them != qNo {
+				continue
+			}
+			st.them = qWantYesEmpty
+		}
+		if err := s.sendCmd(o.cmd, o.opt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// handleIAC reads the rest of an IAC sequence and drives the option
+// negotiation state machine (or parses a subnegotiation). Returns true
+// if an IAC was consumed.
+func (s *session) handleIAC(firstByte byte) (bool, error) {
+	if firstByte != IAC {
+		return false, nil
+	}
+
+	cmd := make([]byte, 1)
+	if _, err := s.conn.Read(cmd); err != nil {
+		return true, err
+	}
+
+	switch cmd[0] {
+	case DO:
+		opt := make([]byte, 1)
+		if _, err := s.conn.Read(opt); err != nil {
+			return true, err
+		}
+		return true, s.recvDO(opt[0])
+
+	case DONT:
+		opt := make([]byte, 1)
+		if _, err := s.conn.Read(opt); err != nil {
+			return true, err
+		}
+		return true, s.recvDONT(opt[0])
+
+	case WILL:
+		opt := make([]byte, 1)
+		if _, err := s.conn.Read(opt); err != nil {
+			return true, err
+		}
+		return true, s.recvWILL(opt[0])
+
+	case WONT:
+		opt := make([]byte, 1)
+		if _, err := s.conn.Read(opt); err != nil {
+			return true, err
+		}
+		return true, s.recvWONT(opt[0])
+
+	case SB:
+		return true, s.handleSubnegotiation()
+
+	default:
+		// Some other 2-byte IAC command (NOP, GA, ...); nothing to do.
+		return true, nil
+	}
+}
+
+// recvDO handles a received "DO opt", i.e. the peer asking us to
+// enable a local option. This is the RFC 1143 "us" side state machine.
+// NAWS describes our terminal, so real servers request it via DO
+// rather than offering WILL themselves; agreeing here is the common
+// path and sends our window size immediately.
+func (s *session) recvDO(opt byte) error {
+	st := s.stateFor(opt)
+
+	switch st.us {
+	case qNo:
+		if s.negotiate[opt] {
+			st.us = qYes
+			if err := s.sendCmd(WILL, opt); err != nil {
+				return err
+			}
+			if opt == optNAWS {
+				if err := s.sendNAWS(); err != nil {
+					return err
+				}
+			}
+		} else {
+			if err := s.sendCmd(WONT, opt); err != nil {
+				return err
+			}
+		}
+	case qYes:
+		// Already enabled; ignore to avoid a negotiation loop.
+	case qWantNoEmpty:
+		st.us = qNo
+	case qWantNoOpposite:
+		st.us = qYes
+	case qWantYesEmpty:
+		st.us = qYes
+		if opt == optNAWS {
+			if err := s.sendNAWS(); err != nil {
+				return err
+			}
+		}
+	case qWantYesOpposite:
+		st.us = qWantNoEmpty
+		if err := s.sendCmd(WONT, opt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recvDONT handles a received "DONT opt".
+func (s *session) recvDONT(opt byte) error {
+	st := s.stateFor(opt)
+
+	switch st.us {
+	case qNo:
+		// Already disabled; ignore.
+	case qYes:
+		st.us = qNo
+		return s.sendCmd(WONT, opt)
+	case qWantNoEmpty:
+		st.us = qNo
+	case qWantNoOpposite:
+		st.us = qWantYesEmpty
+		return s.sendCmd(WILL, opt)
+	case qWantYesEmpty:
+		st.us = qNo
+	case qWantYesOpposite:
+		st.us = qNo
+	}
+
+	return nil
+}
+
+// recvWILL handles a received "WILL opt", i.e. the peer offering to
+// enable a remote option. This is the RFC 1143 "them" side. Agreeing
+// to TTYPE here only sends DO; our terminal type is reported later,
+// reactively, when handleSubnegotiation sees IAC SB TTYPE SEND.
+func (s *session) recvWILL(opt byte) error {
+	st := s.stateFor(opt)
+	wasYes := st.them == qYes
+
+	switch st.them {
+	case qNo:
+		if s.negotiate[opt] {
+			st.them = qYes
+			if err := s.sendCmd(DO, opt); err != nil {
+				return err
+			}
+		} else {
+			if err := s.sendCmd(DONT, opt); err != nil {
+				return err
+			}
+		}
+	case qYes:
+		// Already enabled; ignore.
+	case qWantNoEmpty:
+		st.them = qNo
+	case qWantNoOpposite:
+		st.them = qYes
+	case qWantYesEmpty:
+		st.them = qYes
+	case qWantYesOpposite:
+		st.them = qWantNoEmpty
+		return s.sendCmd(DONT, opt)
+	}
+
+	if opt == optNAWS && !wasYes && st.them == qYes {
+		return s.sendNAWS()
+	}
+
+	return nil
+}
+
+// recvWONT handles a received "WONT opt".
+func (s *session) recvWONT(opt byte) error {
+	st := s.stateFor(opt)
+
+	switch st.them {
+	case qNo:
+		// Already disabled; ignore.
+	case qYes:
+		st.them = qNo
+		return s.sendCmd(DONT, opt)
+	case qWantNoEmpty:
+		st.them = qNo
+	case qWantNoOpposite:
+		st.them = qWantYesEmpty
+		return s.sendCmd(DO, opt)
+	case qWantYesEmpty:
+		st.them = qNo
+	case qWantYesOpposite:
+		st.them = qNo
+	}
+
+	return nil
+}
+
+// handleSubnegotiation reads an IAC SB ... IAC SE block and parses it
+// into a typed event instead of silently discarding it.
+func (s *session) handleSubnegotiation() error {
+	var payload []byte
+	one := make([]byte, 1)
+
+	for {
+		if _, err := s.conn.Read(one); err != nil {
+			return err
+		}
+		if one[0] == IAC {
+			if _, err := s.conn.Read(one); err != nil {
+				return err
+			}
+			if one[0] == SE {
+				break
+			}
+			if one[0] == IAC {
+				payload = append(payload, IAC)
+				continue
+			}
+		}
+		payload = append(payload, one[0])
+	}
+
+	if len(payload) == 0 {
+		return nil
+	}
+
+	switch payload[0] {
+	case optTType:
+		if len(payload) >= 2 && payload[1] == ttypeSEND {
+			return s.sendTTYPESubnegotiation()
+		}
+	}
+
+	return nil
+}
+
+// sendTTYPESubnegotiation answers an IAC SB TTYPE SEND IAC SE with our
+// configured terminal type: IAC SB TTYPE IS <name> IAC SE.
+func (s *session) sendTTYPESubnegotiation() error {
+	out := []byte{IAC, SB, optTType, ttypeIS}
+	out = append(out, []byte(s.termType)...)
+	out = append(out, IAC, SE)
+	_, err := s.conn.Write(out)
+	return err
+}
+
+// sendNAWS emits our window size: IAC SB NAWS w1 w2 h1 h2 IAC SE.
+func (s *session) sendNAWS() error {
+	out := []byte{
+		IAC, SB, optNAWS,
+		byte(s.cols >> 8), byte(s.cols),
+		byte(s.rows >> 8), byte(s.rows),
+		IAC, SE,
+	}
+	_, err := s.conn.Write(out)
+	return err
+}
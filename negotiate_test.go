@@ -0,0 +1,212 @@
+package telnet
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestSession wires a session to one end of an in-memory net.Pipe,
+// giving tests direct control over what bytes arrive on the wire and
+// the ability to inspect what the session writes back.
+func newTestSession(negotiate map[byte]bool) (*session, net.Conn) {
+	local, remote := net.Pipe()
+	return newSession(local, Schema{NegotiateOptions: negotiate}), remote
+}
+
+// readIAC reads and handles one IAC command the way the real pump loop
+// does: one byte at a time, handing each byte to handleIAC, which then
+// reads whatever else the command needs (opt byte, subnegotiation body)
+// directly off the connection.
+func readIAC(t *testing.T, s *session) {
+	t.Helper()
+	one := make([]byte, 1)
+	if _, err := s.conn.Read(one); err != nil {
+		t.Fatalf("reading IAC: %v", err)
+	}
+	handled, err := s.handleIAC(one[0])
+	if err != nil {
+		t.Fatalf("handleIAC: %v", err)
+	}
+	if !handled {
+		t.Fatalf("handleIAC(%#x) = false, want true", one[0])
+	}
+}
+
+func readFull(conn net.Conn, buf []byte) (int, error) {
+	total := 0
+	for total < len(buf) {
+		n, err := conn.Read(buf[total:])
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// exchange plays the "peer" side of the wire: it writes stimulus, then
+// reads back replyLen bytes of whatever the session writes in
+// response, while the test goroutine drives the session's side of the
+// same exchange via readIAC. Both sides have to run concurrently
+// because net.Pipe has no internal buffering - a Write on one half
+// blocks until a Read on the other half consumes it.
+func exchange(t *testing.T, s *session, remote net.Conn, stimulus []byte, replyLen int) []byte {
+	t.Helper()
+
+	respCh := make(chan []byte, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		if _, err := remote.Write(stimulus); err != nil {
+			errCh <- fmt.Errorf("writing stimulus: %w", err)
+			return
+		}
+		buf := make([]byte, replyLen)
+		if _, err := readFull(remote, buf); err != nil {
+			errCh <- fmt.Errorf("reading reply: %w", err)
+			return
+		}
+		respCh <- buf
+	}()
+
+	readIAC(t, s)
+
+	select {
+	case got := <-respCh:
+		return got
+	case err := <-errCh:
+		t.Fatalf("exchange: %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("exchange: timed out waiting for reply")
+	}
+	return nil
+}
+
+func TestRecvDOAgreesWhenNegotiated(t *testing.T) {
+	s, remote := newTestSession(map[byte]bool{optSGA: true})
+	defer remote.Close()
+
+	want := []byte{IAC, WILL, optSGA}
+	got := exchange(t, s, remote, []byte{IAC, DO, optSGA}, len(want))
+	if !bytes.Equal(got, want) {
+		t.Fatalf("reply = % x, want % x", got, want)
+	}
+	if st := s.stateFor(optSGA); st.us != qYes {
+		t.Fatalf("us state = %v, want qYes", st.us)
+	}
+}
+
+func TestRecvDORefusesWhenNotNegotiated(t *testing.T) {
+	s, remote := newTestSession(map[byte]bool{optSGA: false})
+	defer remote.Close()
+
+	want := []byte{IAC, WONT, optSGA}
+	got := exchange(t, s, remote, []byte{IAC, DO, optSGA}, len(want))
+	if !bytes.Equal(got, want) {
+		t.Fatalf("reply = % x, want % x", got, want)
+	}
+	if st := s.stateFor(optSGA); st.us != qNo {
+		t.Fatalf("us state = %v, want qNo", st.us)
+	}
+}
+
+func TestRecvDONTDisablesEnabledOption(t *testing.T) {
+	s, remote := newTestSession(map[byte]bool{optSGA: true})
+	defer remote.Close()
+	s.stateFor(optSGA).us = qYes
+
+	want := []byte{IAC, WONT, optSGA}
+	got := exchange(t, s, remote, []byte{IAC, DONT, optSGA}, len(want))
+	if !bytes.Equal(got, want) {
+		t.Fatalf("reply = % x, want % x", got, want)
+	}
+	if st := s.stateFor(optSGA); st.us != qNo {
+		t.Fatalf("us state = %v, want qNo", st.us)
+	}
+}
+
+func TestRecvWILLTTypeRepliesDOOnly(t *testing.T) {
+	s, remote := newTestSession(map[byte]bool{optTType: true})
+	s.termType = "vt100"
+	defer remote.Close()
+
+	// Agreeing to WILL TTYPE must not eagerly send our terminal type:
+	// that's only sent reactively, in response to IAC SB TTYPE SEND
+	// (see TestSubnegotiationTTypeSendTriggersReply).
+	want := []byte{IAC, DO, optTType}
+
+	got := exchange(t, s, remote, []byte{IAC, WILL, optTType}, len(want))
+	if !bytes.Equal(got, want) {
+		t.Fatalf("reply = % x, want % x", got, want)
+	}
+	if st := s.stateFor(optTType); st.them != qYes {
+		t.Fatalf("them state = %v, want qYes", st.them)
+	}
+}
+
+func TestRecvDONAWSAgreesAndSendsWindowSize(t *testing.T) {
+	// The realistic direction: a server (Cisco/Juniper-style) asks the
+	// client to report its window size via DO NAWS, rather than
+	// offering WILL NAWS itself.
+	s, remote := newTestSession(map[byte]bool{optNAWS: true})
+	s.cols = 132
+	s.rows = 43
+	defer remote.Close()
+
+	want := []byte{IAC, WILL, optNAWS, IAC, SB, optNAWS, 0, 132, 0, 43, IAC, SE}
+
+	got := exchange(t, s, remote, []byte{IAC, DO, optNAWS}, len(want))
+	if !bytes.Equal(got, want) {
+		t.Fatalf("reply = % x, want % x", got, want)
+	}
+	if st := s.stateFor(optNAWS); st.us != qYes {
+		t.Fatalf("us state = %v, want qYes", st.us)
+	}
+}
+
+func TestRecvWILLNAWSRepliesDOThenSendsWindowSize(t *testing.T) {
+	s, remote := newTestSession(map[byte]bool{optNAWS: true})
+	s.cols = 132
+	s.rows = 43
+	defer remote.Close()
+
+	want := []byte{IAC, DO, optNAWS, IAC, SB, optNAWS, 0, 132, 0, 43, IAC, SE}
+
+	got := exchange(t, s, remote, []byte{IAC, WILL, optNAWS}, len(want))
+	if !bytes.Equal(got, want) {
+		t.Fatalf("reply = % x, want % x", got, want)
+	}
+	if st := s.stateFor(optNAWS); st.them != qYes {
+		t.Fatalf("them state = %v, want qYes", st.them)
+	}
+}
+
+func TestRecvWILLRefusesWhenNotNegotiated(t *testing.T) {
+	s, remote := newTestSession(map[byte]bool{optLinemode: false})
+	defer remote.Close()
+
+	want := []byte{IAC, DONT, optLinemode}
+	got := exchange(t, s, remote, []byte{IAC, WILL, optLinemode}, len(want))
+	if !bytes.Equal(got, want) {
+		t.Fatalf("reply = % x, want % x", got, want)
+	}
+	if st := s.stateFor(optLinemode); st.them != qNo {
+		t.Fatalf("them state = %v, want qNo", st.them)
+	}
+}
+
+func TestSubnegotiationTTypeSendTriggersReply(t *testing.T) {
+	s, remote := newTestSession(nil)
+	s.termType = "xterm-256color"
+	defer remote.Close()
+
+	want := append([]byte{IAC, SB, optTType, ttypeIS}, []byte("xterm-256color")...)
+	want = append(want, IAC, SE)
+
+	got := exchange(t, s, remote, []byte{IAC, SB, optTType, ttypeSEND, IAC, SE}, len(want))
+	if !bytes.Equal(got, want) {
+		t.Fatalf("reply = % x, want % x", got, want)
+	}
+}
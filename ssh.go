@@ -0,0 +1,187 @@
+package telnet
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	sshKeepaliveInterval = 30 * time.Second
+	sshKeepaliveTimeout  = 10 * time.Second
+)
+
+// sshCloser tears down an SSH session, client and the underlying TCP
+// connection in order.
+type sshCloser struct {
+	session *ssh.Session
+	client  *ssh.Client
+	conn    net.Conn
+}
+
+func (c sshCloser) Close() error {
+	c.session.Close()
+	c.client.Close()
+	return c.conn.Close()
+}
+
+// dialSSH connects to conf.Server:Port over SSH, authenticating with
+// Password and/or PrivateKey, opens a session and starts a shell,
+// returning a transport over its stdin/stdout pipes. The telnet IAC
+// handler is never involved on this path.
+func dialSSH(ctx context.Context, conf Schema, deadline time.Time) (*transport, io.Closer, error) {
+	connStr := fmt.Sprintf("%s:%d", conf.Server, conf.Port)
+
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, "tcp", connStr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to dial %s: %w", connStr, err)
+	}
+	conn.SetDeadline(deadline)
+
+	auth, err := sshAuthMethods(conf)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	clientConf := &ssh.ClientConfig{
+		User:            conf.Username,
+		Auth:            auth,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         time.Until(deadline),
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, connStr, clientConf)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed ssh handshake with %s: %w", connStr, err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+
+	sshSess, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, nil, fmt.Errorf("failed opening ssh session: %w", err)
+	}
+
+	// Run/Client always drive an interactive Shell() below, so a PTY
+	// is requested unconditionally rather than gated on Steps (which
+	// Dial's Options never carries anyway).
+	termType, rows, cols := ptyDimensions(conf)
+	if err := sshSess.RequestPty(termType, rows, cols, ssh.TerminalModes{}); err != nil {
+		sshSess.Close()
+		client.Close()
+		return nil, nil, fmt.Errorf("failed requesting pty: %w", err)
+	}
+
+	stdin, err := sshSess.StdinPipe()
+	if err != nil {
+		sshSess.Close()
+		client.Close()
+		return nil, nil, fmt.Errorf("failed opening ssh stdin: %w", err)
+	}
+
+	stdout, err := sshSess.StdoutPipe()
+	if err != nil {
+		sshSess.Close()
+		client.Close()
+		return nil, nil, fmt.Errorf("failed opening ssh stdout: %w", err)
+	}
+
+	if err := sshSess.Shell(); err != nil {
+		sshSess.Close()
+		client.Close()
+		return nil, nil, fmt.Errorf("failed starting ssh shell: %w", err)
+	}
+
+	go sshKeepalive(client, conn, sshKeepaliveInterval, sshKeepaliveTimeout)
+
+	tr := &transport{
+		r:               stdout,
+		w:               stdin,
+		setReadDeadline: conn.SetReadDeadline,
+	}
+	closer := sshCloser{session: sshSess, client: client, conn: conn}
+
+	return tr, closer, nil
+}
+
+// ptyDimensions applies the same defaults newSession uses for the
+// telnet path, since Schema values reaching dialSSH via Dial's Options
+// skip the JSON schema's `default` tag handling.
+func ptyDimensions(conf Schema) (termType string, rows, cols int) {
+	termType = conf.TerminalType
+	if termType == "" {
+		termType = "xterm"
+	}
+	rows = conf.Rows
+	if rows <= 0 {
+		rows = 24
+	}
+	cols = conf.Cols
+	if cols <= 0 {
+		cols = 80
+	}
+	return termType, rows, cols
+}
+
+func sshAuthMethods(conf Schema) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+
+	if conf.PrivateKey != "" {
+		var signer ssh.Signer
+		var err error
+		if conf.PrivateKeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(conf.PrivateKey), []byte(conf.PrivateKeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey([]byte(conf.PrivateKey))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing private key: %w", err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+
+	if conf.Password != "" {
+		methods = append(methods, ssh.Password(conf.Password))
+	}
+
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("ssh scheme requires password or private_key")
+	}
+
+	return methods, nil
+}
+
+// sshKeepalive periodically sends a keepalive@openssh.com global
+// request and closes conn if no reply arrives within timeout, so a
+// stuck middlebox doesn't leave the session hanging until the context
+// deadline.
+func sshKeepalive(client *ssh.Client, conn net.Conn, interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		done := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			done <- err
+		}()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				conn.Close()
+				return
+			}
+		case <-time.After(timeout):
+			conn.Close()
+			return
+		}
+	}
+}
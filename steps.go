@@ -0,0 +1,190 @@
+package telnet
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Step describes one round of an expect/send dialogue: wait for one of
+// several targets, then optionally send a response. It generalizes the
+// old hardcoded login -> password -> prompt -> command -> prompt flow
+// so devices with banners, paging prompts or enable-mode escalation can
+// be scripted.
+//
+// Step is decoded with encoding/json (see Schema.Steps and
+// parseConfig), not github.com/scorify/schema, since the latter has no
+// support for slices or nested structs; its tags are "json", not the
+// rest of this package's "key".
+type Step struct {
+	// ExpectAny matches if the read buffer contains any of these
+	// substrings.
+	ExpectAny []string `json:"expect_any"`
+	// ExpectRegex matches if the read buffer matches any of these
+	// regular expressions. Evaluated alongside ExpectAny; whichever
+	// target matches first wins.
+	ExpectRegex []string `json:"expect_regex"`
+
+	// Send is written followed by "\r\n". ${var} references are
+	// expanded from values captured by earlier steps' StoreAs.
+	Send string `json:"send"`
+	// SendRaw is written verbatim, with no trailing newline appended.
+	// Use it for control characters such as "^C" (0x03) or "^Z" (0x1A).
+	SendRaw []byte `json:"send_raw"`
+
+	// Timeout bounds how long this step waits for a match. Zero means
+	// no per-step deadline beyond the overall context. It is JSON
+	// nanoseconds, i.e. time.Duration's native encoding/json shape.
+	Timeout time.Duration `json:"timeout"`
+
+	// StoreAs, if set, saves the matched text under this name so later
+	// steps can reference it as ${name} in Send.
+	StoreAs string `json:"store_as"`
+}
+
+// compiledStep holds a Step plus its compiled regex targets, so they
+// are parsed once up front rather than on every read.
+type compiledStep struct {
+	step    Step
+	regexes []*regexp.Regexp
+	// targetCount is len(ExpectAny)+len(ExpectRegex); ExpectAny
+	// targets occupy indices [0,len(ExpectAny)) and ExpectRegex
+	// targets occupy the remainder, so callers can tell which matched.
+	targetCount int
+}
+
+func compileSteps(steps []Step) ([]compiledStep, error) {
+	out := make([]compiledStep, 0, len(steps))
+	for _, st := range steps {
+		cs := compiledStep{step: st, targetCount: len(st.ExpectAny) + len(st.ExpectRegex)}
+		for _, pattern := range st.ExpectRegex {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid expect_regex %q: %w", pattern, err)
+			}
+			cs.regexes = append(cs.regexes, re)
+		}
+		out = append(out, cs)
+	}
+	return out, nil
+}
+
+// defaultSteps builds the classic login -> password -> shell prompt ->
+// command -> expected output script from the legacy Schema fields, used
+// whenever Schema.Steps is empty.
+func defaultSteps(conf Schema) []Step {
+	return []Step{
+		{ExpectAny: []string{"ogin:"}, Send: conf.Username},
+		{ExpectAny: []string{"assword:"}, Send: conf.Password},
+		{ExpectAny: []string{"$ ", "# "}},
+		{Send: conf.Command},
+		{ExpectRegex: []string{regexp.QuoteMeta(conf.ExpectedOutput)}},
+	}
+}
+
+// stepError is returned when a scripted dialogue fails, enumerating
+// which step failed, what it expected, and the last bytes read so
+// callers can diagnose a bad script or an unexpected device prompt.
+type stepError struct {
+	stepIndex int
+	expected  []string
+	lastBytes string
+	cause     error
+}
+
+func (e *stepError) Error() string {
+	return fmt.Sprintf(
+		"step %d: expected one of %v, got (last %d bytes) %q: %v",
+		e.stepIndex, e.expected, len(e.lastBytes), e.lastBytes, e.cause,
+	)
+}
+
+func (e *stepError) Unwrap() error {
+	return e.cause
+}
+
+const lastBytesWindow = 256
+
+// runSteps drives the scripted expect/send dialogue over client,
+// expanding ${var} references from StoreAs captures, and returns the
+// final step's matched text.
+func runSteps(ctx context.Context, client *Client, steps []Step) (string, error) {
+	compiled, err := compileSteps(steps)
+	if err != nil {
+		return "", err
+	}
+
+	vars := map[string]string{}
+	var lastMatch string
+
+	for i, cs := range compiled {
+		vars["__last"] = lastMatch
+
+		if len(cs.step.SendRaw) > 0 {
+			if err := client.Send(string(cs.step.SendRaw)); err != nil {
+				return "", fmt.Errorf("step %d: failed sending raw bytes: %w", i, err)
+			}
+		} else if cs.step.Send != "" {
+			if err := client.SendLine(expandVars(cs.step.Send, vars)); err != nil {
+				return "", fmt.Errorf("step %d: failed sending: %w", i, err)
+			}
+		}
+
+		if cs.targetCount == 0 {
+			continue
+		}
+
+		_ = client.SetReadDeadline(stepDeadline(ctx, cs.step.Timeout))
+
+		matched, _, err := client.expect(cs.step.ExpectAny, cs.regexes)
+		if err != nil {
+			return "", &stepError{
+				stepIndex: i,
+				expected:  allTargets(cs.step),
+				lastBytes: lastN(matched, lastBytesWindow),
+				cause:     err,
+			}
+		}
+
+		lastMatch = matched
+		if cs.step.StoreAs != "" {
+			vars[cs.step.StoreAs] = matched
+		}
+	}
+
+	return lastMatch, nil
+}
+
+func allTargets(step Step) []string {
+	return append(append([]string{}, step.ExpectAny...), step.ExpectRegex...)
+}
+
+func stepDeadline(ctx context.Context, timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		ctxDeadline, _ := ctx.Deadline()
+		return ctxDeadline
+	}
+	d := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(d) {
+		return ctxDeadline
+	}
+	return d
+}
+
+func lastN(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[len(s)-n:]
+}
+
+// expandVars replaces ${name} references in s with values captured by
+// earlier steps' StoreAs.
+func expandVars(s string, vars map[string]string) string {
+	for name, val := range vars {
+		s = strings.ReplaceAll(s, "${"+name+"}", val)
+	}
+	return s
+}
@@ -0,0 +1,40 @@
+package telnet
+
+import "testing"
+
+func TestExpandVars(t *testing.T) {
+	vars := map[string]string{"user": "alice"}
+
+	got := expandVars("hello ${user}", vars)
+	want := "hello alice"
+	if got != want {
+		t.Fatalf("expandVars() = %q, want %q", got, want)
+	}
+}
+
+func TestDefaultStepsMatchesLegacyFlow(t *testing.T) {
+	conf := Schema{
+		Username:       "alice",
+		Password:       "hunter2",
+		Command:        "uptime",
+		ExpectedOutput: "load average",
+	}
+
+	steps := defaultSteps(conf)
+	if len(steps) != 5 {
+		t.Fatalf("defaultSteps() returned %d steps, want 5", len(steps))
+	}
+
+	if steps[0].Send != conf.Username {
+		t.Errorf("step 0 Send = %q, want username %q", steps[0].Send, conf.Username)
+	}
+	if steps[1].Send != conf.Password {
+		t.Errorf("step 1 Send = %q, want password %q", steps[1].Send, conf.Password)
+	}
+	if steps[3].Send != conf.Command {
+		t.Errorf("step 3 Send = %q, want command %q", steps[3].Send, conf.Command)
+	}
+	if len(steps[4].ExpectRegex) != 1 {
+		t.Fatalf("final step should have exactly one ExpectRegex target")
+	}
+}
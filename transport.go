@@ -0,0 +1,25 @@
+package telnet
+
+import (
+	"io"
+	"time"
+)
+
+// transport is the I/O surface the expect/send engine drives: a
+// reader/writer pair, an optional telnet IAC interceptor, and an
+// optional read deadline setter. The telnet path populates iac with
+// session.handleIAC so option negotiation keeps working transparently;
+// the SSH path leaves it nil since SSH has no IAC layer.
+type transport struct {
+	r io.Reader
+	w io.Writer
+
+	// iac, if set, is offered every byte read and may consume a
+	// telnet IAC sequence instead of passing it through to the
+	// expect/send matcher.
+	iac func(firstByte byte) (handled bool, err error)
+
+	// setReadDeadline, if set, bounds the next read. nil when the
+	// underlying transport doesn't support deadlines.
+	setReadDeadline func(time.Time) error
+}